@@ -16,13 +16,53 @@ import (
 
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
 	ProviderName = "gpkg"
 	FilePath     = "FilePath"
 	DefaultSRID  = tegola.WebMercator
+
+	// ClipGeometry is the config key for an optional path to a GeoJSON
+	// polygon/multipolygon used to constrain which features MVTLayer returns,
+	// mirroring the limitto clipping polygon used elsewhere for imports.
+	ClipGeometry = "ClipGeometry"
+
+	// LayersKey is the config key for the optional array of per-layer config,
+	// mirroring the postgis provider.
+	LayersKey          = "layers"
+	LayerName          = "name"
+	LayerTablename     = "tablename"
+	LayerSQL           = "sql"
+	LayerIDFieldname   = "id_fieldname"
+	LayerGeomFieldname = "geometry_fieldname"
+	LayerSRID          = "srid"
+	LayerMinZoom       = "min_zoom"
+	LayerMaxZoom       = "max_zoom"
+	LayerFields        = "fields"
+
+	DefaultIDFieldName   = "gid"
+	DefaultGeomFieldName = "geom"
+	DefaultMinZoom       = 0
+	DefaultMaxZoom       = 20
+
+	// bboxToken and zoomToken are substituted into a layer's "sql" config
+	// with the current tile's bounding box (in the layer's native SRID) and
+	// zoom level, respectively.
+	bboxToken = "!BBOX!"
+	zoomToken = "!ZOOM!"
+
+	// maxOpenConns bounds the shared connection pool opened once in
+	// NewProvider and reused by every MVTLayer call.
+	maxOpenConns = 8
 )
 
 // layer holds information about a query.
@@ -40,6 +80,245 @@ type layer struct {
 	geomType tegola.Geometry
 	// The SRID that the data in the table is stored in. This will default to WebMercator
 	srid int
+	// Whether gpkg_extensions registers a gpkg_rtree_index extension for this layer's geometry column
+	hasRTree bool
+	// The name of the rtree virtual table to join against when hasRTree is true
+	rtreeTable string
+	// The min/max zoom this layer is visible at. Defaults to DefaultMinZoom/DefaultMaxZoom.
+	minZoom int
+	maxZoom int
+	// Allow-list of non-geometry, non-id columns to expose as mvt.Feature tags.
+	// Empty means all columns are exposed.
+	fields []string
+	// reprojector converts a vertex in srid to WebMercator, resolved once at
+	// load time from gpkg_spatial_ref_sys. nil when srid == DefaultSRID.
+	reprojector Reprojector
+	// srsUndefined is set when srid has no usable entry in
+	// gpkg_spatial_ref_sys; features are logged and skipped rather than
+	// aborting the whole tile.
+	srsUndefined bool
+}
+
+// Reprojector converts a single vertex from a layer's native SRID into
+// WebMercator. Implementations are resolved once per layer at load time from
+// the definition registered in gpkg_spatial_ref_sys. MVTLayer applies them
+// per-vertex to each feature's geometry after wkb.Decode has decoded it; the
+// WKB decode itself does not reproject.
+type Reprojector interface {
+	Reproject(x, y float64) (float64, float64, error)
+	// Unreproject is Reproject's inverse: it converts a WebMercator vertex
+	// back into the layer's native SRID. MVTLayer uses it to reproject the
+	// requested tile's bbox down to the layer's SRID before querying, the
+	// same WKT-derived projection Reproject uses per-feature afterwards.
+	Unreproject(x, y float64) (float64, float64, error)
+}
+
+// wktReprojector converts a vertex from the CRS described by a
+// gpkg_spatial_ref_sys WKT definition into WebMercator: geographic (lon/lat)
+// coordinates go straight through the spherical Web Mercator formula;
+// projected coordinates are first inverted back to lon/lat using the CRS's
+// own projection and parameters. Resolved once per layer by parseWKT +
+// lookupReprojector; unsupported projections are rejected at that point
+// rather than silently passed through.
+type wktReprojector struct {
+	crs  *wktCRS
+	lon0 float64 // central_meridian, degrees
+	k0   float64 // scale_factor
+	fe   float64 // false_easting
+	fn   float64 // false_northing
+}
+
+func (r wktReprojector) Reproject(x, y float64) (float64, float64, error) {
+	lon, lat := x, y
+	if !r.crs.isGeographic {
+		switch strings.ToLower(r.crs.projection) {
+		case "transverse_mercator":
+			lon, lat = inverseTransverseMercator(x, y, r.crs.semiMajor, r.crs.invFlattening, r.lon0, r.k0, r.fe, r.fn)
+		default:
+			return 0, 0, fmt.Errorf("gpkg: unsupported WKT projection %q", r.crs.projection)
+		}
+	}
+	wx, wy := forwardWebMercator(lon, lat)
+	return wx, wy, nil
+}
+
+func (r wktReprojector) Unreproject(x, y float64) (float64, float64, error) {
+	lon, lat := inverseWebMercator(x, y)
+	if r.crs.isGeographic {
+		return lon, lat, nil
+	}
+	switch strings.ToLower(r.crs.projection) {
+	case "transverse_mercator":
+		nx, ny := forwardTransverseMercator(lon, lat, r.crs.semiMajor, r.crs.invFlattening, r.lon0, r.k0, r.fe, r.fn)
+		return nx, ny, nil
+	default:
+		return 0, 0, fmt.Errorf("gpkg: unsupported WKT projection %q", r.crs.projection)
+	}
+}
+
+// earthRadius is the sphere radius EPSG:3857 (WebMercator) is defined
+// against, regardless of the source CRS's own ellipsoid.
+const earthRadius = 6378137.0
+
+// forwardWebMercator projects WGS84 lon/lat (degrees) into WebMercator
+// (metres), using the same spherical formula as EPSG:3857.
+func forwardWebMercator(lonDeg, latDeg float64) (x, y float64) {
+	lonRad := lonDeg * math.Pi / 180
+	latRad := latDeg * math.Pi / 180
+	x = earthRadius * lonRad
+	y = earthRadius * math.Log(math.Tan(math.Pi/4+latRad/2))
+	return x, y
+}
+
+// inverseWebMercator converts WebMercator (metres) back into WGS84 lon/lat
+// (degrees), the reverse of forwardWebMercator.
+func inverseWebMercator(x, y float64) (lonDeg, latDeg float64) {
+	lonDeg = x / earthRadius * 180 / math.Pi
+	latDeg = (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lonDeg, latDeg
+}
+
+// forwardTransverseMercator converts WGS84 lon/lat (degrees) into projected
+// easting/northing (metres), using the same ellipsoidal Transverse Mercator
+// series (Snyder, USGS PP 1395) as inverseTransverseMercator, just run the
+// other direction. Like its inverse, it assumes latitude_of_origin is the
+// equator.
+func forwardTransverseMercator(lonDeg, latDeg, a, invF, lon0Deg, k0, falseEasting, falseNorthing float64) (x, y float64) {
+	f := 1 / invF
+	e2 := f * (2 - f)
+	ep2 := e2 / (1 - e2)
+
+	lat := latDeg * math.Pi / 180
+	lon := lonDeg * math.Pi / 180
+	lon0 := lon0Deg * math.Pi / 180
+
+	sinLat, cosLat, tanLat := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	n := a / math.Sqrt(1-e2*sinLat*sinLat)
+	t := tanLat * tanLat
+	c := ep2 * cosLat * cosLat
+	aa := (lon - lon0) * cosLat
+
+	m := a * ((1-e2/4-3*e2*e2/64-5*e2*e2*e2/256)*lat -
+		(3*e2/8+3*e2*e2/32+45*e2*e2*e2/1024)*math.Sin(2*lat) +
+		(15*e2*e2/256+45*e2*e2*e2/1024)*math.Sin(4*lat) -
+		(35*e2*e2*e2/3072)*math.Sin(6*lat))
+
+	x = falseEasting + k0*n*(aa+
+		(1-t+c)*aa*aa*aa/6+
+		(5-18*t+t*t+72*c-58*ep2)*aa*aa*aa*aa*aa/120)
+
+	y = falseNorthing + k0*(m+n*tanLat*(aa*aa/2+
+		(5-t+9*c+4*c*c)*aa*aa*aa*aa/24+
+		(61-58*t+t*t+600*c-330*ep2)*aa*aa*aa*aa*aa*aa/720))
+
+	return x, y
+}
+
+// inverseTransverseMercator converts projected easting/northing (metres)
+// back to lon/lat (degrees) using the ellipsoidal Transverse Mercator series
+// from Snyder, "Map Projections: A Working Manual" (USGS PP 1395), the same
+// formulas behind most UTM inverse implementations. latitude_of_origin is
+// assumed to be the equator, true of every UTM zone definition and of most
+// other Transverse Mercator CRSes seen in the wild.
+func inverseTransverseMercator(x, y, a, invF, lon0Deg, k0, falseEasting, falseNorthing float64) (lonDeg, latDeg float64) {
+	f := 1 / invF
+	e2 := f * (2 - f)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	x -= falseEasting
+	y -= falseNorthing
+
+	m := y / k0
+	mu := m / (a * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+
+	phi1 := mu +
+		(3*e1/2-27*e1*e1*e1/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*e1*e1*e1*e1/32)*math.Sin(4*mu) +
+		(151*e1*e1*e1/96)*math.Sin(6*mu) +
+		(1097*e1*e1*e1*e1/512)*math.Sin(8*mu)
+
+	sinPhi1, cosPhi1, tanPhi1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cosPhi1 * cosPhi1
+	t1 := tanPhi1 * tanPhi1
+	n1 := a / math.Sqrt(1-e2*sinPhi1*sinPhi1)
+	r1 := a * (1 - e2) / math.Pow(1-e2*sinPhi1*sinPhi1, 1.5)
+	d := x / (n1 * k0)
+
+	lat := phi1 - (n1*tanPhi1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*d*d*d*d/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*d*d*d*d*d*d/720)
+
+	lon := lon0Deg*math.Pi/180 + (d-
+		(1+2*t1+c1)*d*d*d/6+
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*d*d*d*d*d/120)/cosPhi1
+
+	return lon * 180 / math.Pi, lat * 180 / math.Pi
+}
+
+// wktCRS is the subset of a gpkg_spatial_ref_sys WKT (OGC WKT1) definition
+// parseWKT extracts: enough to tell a geographic CRS from a projected one and
+// to drive the projections wktReprojector supports.
+type wktCRS struct {
+	isGeographic  bool
+	projection    string
+	params        map[string]float64
+	semiMajor     float64
+	invFlattening float64
+}
+
+var (
+	wktProjectionRe = regexp.MustCompile(`PROJECTION\["([^"]+)"\]`)
+	wktParameterRe  = regexp.MustCompile(`PARAMETER\["([^"]+)",\s*([-0-9.eE]+)\]`)
+	wktSpheroidRe   = regexp.MustCompile(`SPHEROID\["[^"]*",\s*([-0-9.eE]+),\s*([-0-9.eE]+)`)
+)
+
+// parseWKT extracts the handful of fields wktReprojector needs out of an OGC
+// WKT1 CRS definition, as stored in gpkg_spatial_ref_sys.definition. It does
+// not attempt to parse WKT generally - only GEOGCS/PROJCS, PROJECTION,
+// PARAMETER and SPHEROID nodes, which is what every CRS tegola can reproject
+// actually uses.
+func parseWKT(wkt string) (*wktCRS, error) {
+	upper := strings.ToUpper(strings.TrimSpace(wkt))
+	crs := &wktCRS{params: make(map[string]float64)}
+	switch {
+	case strings.HasPrefix(upper, "GEOGCS"):
+		crs.isGeographic = true
+	case strings.HasPrefix(upper, "PROJCS"):
+		crs.isGeographic = false
+	default:
+		return nil, fmt.Errorf("unsupported WKT root node in definition %q", wkt)
+	}
+
+	if m := wktProjectionRe.FindStringSubmatch(wkt); m != nil {
+		crs.projection = m[1]
+	}
+	for _, m := range wktParameterRe.FindAllStringSubmatch(wkt, -1) {
+		if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+			crs.params[strings.ToLower(m[1])] = v
+		}
+	}
+
+	crs.semiMajor = 6378137.0
+	crs.invFlattening = 298.257223563
+	if m := wktSpheroidRe.FindStringSubmatch(wkt); m != nil {
+		if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+			crs.semiMajor = v
+		}
+		if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+			crs.invFlattening = v
+		}
+	}
+
+	return crs, nil
+}
+
+// wktSupportedProjections lists the PROJCS PROJECTION[] names wktReprojector
+// knows how to invert. Anything else is rejected by lookupReprojector at load
+// time, rather than discovered as every feature silently failing to reproject
+// at serve time.
+var wktSupportedProjections = map[string]bool{
+	"transverse_mercator": true,
 }
 
 type GPKGProvider struct {
@@ -48,6 +327,47 @@ type GPKGProvider struct {
 	// map of layer name and corrosponding sql
 	layers map[string]layer
 	srid   int
+	// Optional clip mask loaded from the ClipGeometry config option. When set,
+	// MVTLayer drops features entirely outside it and clips the rest to it.
+	clipMask []basic.Polygon
+
+	// db is opened once in NewProvider and shared by every MVTLayer call,
+	// rather than each call opening (and leaking) its own handle.
+	db *sql.DB
+	// stmts caches prepared statements keyed by query text, for layer queries
+	// whose bbox (if any) is passed as bind parameters; queries built from
+	// !BBOX!/!ZOOM! tokens are run unprepared and never cached. Guarded by
+	// stmtsMu since MVTLayer may be called concurrently.
+	stmts   map[string]*sql.Stmt
+	stmtsMu sync.Mutex
+}
+
+// Close releases the provider's shared db handle and prepared statements. The
+// tegola server should call this on shutdown/reload.
+func (p *GPKGProvider) Close() error {
+	p.stmtsMu.Lock()
+	defer p.stmtsMu.Unlock()
+	for _, stmt := range p.stmts {
+		stmt.Close()
+	}
+	return p.db.Close()
+}
+
+// stmt returns the cached prepared statement for qtext, preparing and
+// caching it on first use.
+func (p *GPKGProvider) stmt(qtext string) (*sql.Stmt, error) {
+	p.stmtsMu.Lock()
+	defer p.stmtsMu.Unlock()
+
+	if stmt, ok := p.stmts[qtext]; ok {
+		return stmt, nil
+	}
+	stmt, err := p.db.Prepare(qtext)
+	if err != nil {
+		return nil, err
+	}
+	p.stmts[qtext] = stmt
+	return stmt, nil
 }
 
 type LayerInfo interface {
@@ -85,17 +405,67 @@ func (p *GPKGProvider) Layers() ([]mvt.LayerInfo, error) {
 
 func (p *GPKGProvider) MVTLayer(ctx context.Context, layerName string, tile tegola.Tile, tags map[string]interface{}) (*mvt.Layer, error) {
 	util.CodeLogger.Debugf("GPKGProvider MVTLayer() called for %v", layerName)
-	filepath := p.FilePath
+	var err error
 
-	util.CodeLogger.Infof("Opening gpkg at: ", filepath)
-	db, err := sql.Open("sqlite3", filepath)
-	if err != nil {
-		return nil, err
+	pLayer := p.layers[layerName]
+
+	newLayer := new(mvt.Layer)
+	newLayer.Name = layerName
+
+	if zoom := tile.Z(); zoom < pLayer.minZoom || zoom > pLayer.maxZoom {
+		util.CodeLogger.Debugf("Zoom %v outside of layer (%v) range (%v-%v), skipping", zoom, layerName, pLayer.minZoom, pLayer.maxZoom)
+		return newLayer, nil
 	}
 
-	// Get all feature rows for the layer requested.
-	qtext := fmt.Sprintf("SELECT * FROM %v WHERE geom IS NOT NULL;", layerName)
-	rows, err := db.Query(qtext)
+	// Compute the tile's bounding box in the layer's native SRID so it can be
+	// pushed down to the rtree index or substituted into a !BBOX! token.
+	bbox := tile.BoundingBox()
+	minx, miny, maxx, maxy := bbox.Minx, bbox.Miny, bbox.Maxx, bbox.Maxy
+	if pLayer.srid != DefaultSRID {
+		if pLayer.srsUndefined || pLayer.reprojector == nil {
+			// Every feature in this layer already gets skipped per-row below for
+			// the same reason, so there's no point querying just to find that out.
+			util.CodeLogger.Warnf("Layer (%v) has an unusable SRS (%v), returning an empty layer", layerName, pLayer.srid)
+			return newLayer, nil
+		}
+		minx, miny, maxx, maxy, err = reprojectBBox(pLayer.reprojector, minx, miny, maxx, maxy)
+		if err != nil {
+			util.CodeLogger.Errorf("Unable to reproject tile bbox to SRID (%v) for layer (%v): %v", pLayer.srid, layerName, err)
+			return nil, err
+		}
+	}
+
+	// Get feature rows for the layer requested, restricted to the tile's bbox
+	// via the rtree index when one is registered for this layer, or via the
+	// !BBOX!/!ZOOM! tokens when the layer's sql is a config-driven template.
+	baseSQL := strings.TrimSuffix(strings.TrimSpace(pLayer.sql), ";")
+
+	var qtext string
+	var args []interface{}
+	hasTokens := strings.Contains(baseSQL, bboxToken) || strings.Contains(baseSQL, zoomToken)
+	switch {
+	case hasTokens:
+		// The bbox/zoom are baked in as literals here, so the query text differs
+		// per tile; preparing (and caching) it would just grow the cache unbounded.
+		qtext = substituteTokens(baseSQL, minx, miny, maxx, maxy, tile.Z())
+	case pLayer.hasRTree:
+		qtext = rtreeJoinQuery(baseSQL, pLayer.idField, pLayer.rtreeTable)
+		args = []interface{}{maxx, minx, maxy, miny}
+	default:
+		qtext = baseSQL
+	}
+
+	var rows *sql.Rows
+	if hasTokens {
+		rows, err = p.db.Query(qtext)
+	} else {
+		stmt, serr := p.stmt(qtext)
+		if serr != nil {
+			util.CodeLogger.Errorf("Error preparing query: %v - %v", qtext, serr)
+			return nil, serr
+		}
+		rows, err = stmt.Query(args...)
+	}
 	if err != nil {
 		util.CodeLogger.Errorf("Error during query: %v - %v", qtext, err)
 		return nil, err
@@ -106,6 +476,10 @@ func (p *GPKGProvider) MVTLayer(ctx context.Context, layerName string, tile tego
 	if err != nil {
 		return nil, err
 	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
 
 	vals := make([]interface{}, len(cols))
 	valPtrs := make([]interface{}, len(cols))
@@ -113,29 +487,49 @@ func (p *GPKGProvider) MVTLayer(ctx context.Context, layerName string, tile tego
 		valPtrs[i] = &vals[i]
 	}
 
-	pLayer := p.layers[layerName]
-	newLayer := new(mvt.Layer)
-	newLayer.Name = layerName
-
 	rowCount := 0
+	// skippedCount tracks rows intentionally excluded by normal filtering
+	// (outside the tile bbox, an undefined SRS, or clipped away by
+	// ClipGeometry) so the row/feature count sanity check below flags actual
+	// decode failures instead of firing on every tile a mask or bbox filters.
+	skippedCount := 0
 	var geom tegola.Geometry
 	for rows.Next() {
 		geom = nil
 		rowCount++
+		skippedRow := false
 		err = rows.Scan(valPtrs...)
 		if err != nil {
 			util.CodeLogger.Error(err)
 			continue
 		}
 		var gid uint64
+		tags := make(map[string]interface{})
 
 		for i := 0; i < len(cols); i++ {
-			if cols[i] == "geom" {
+			switch cols[i] {
+			case pLayer.geomField:
 				util.CodeLogger.Debugf("Doing gpkg geometry extraction...", vals[i])
 				var h GeoPackageBinaryHeader
 				geomData := vals[i].([]byte)
 				h.Init(geomData)
 
+				// No rtree index to push the bbox filter down to the query, so fall
+				// back to filtering on the geometry header's envelope, when present.
+				// Envelope(), like Init()/Size()/SRSId(), is GeoPackageBinaryHeader's
+				// own decoding of the optional mbr flagged in the header's flags byte; it
+				// returns ok=false on a header with no envelope rather than needing a guard
+				// here.
+				if !pLayer.hasRTree {
+					if eminx, eminy, emaxx, emaxy, ok := h.Envelope(); ok {
+						if emaxx < minx || eminx > maxx || emaxy < miny || eminy > maxy {
+							// Outside the tile bbox; leave geom nil so the row is skipped below.
+							skippedRow = true
+							continue
+						}
+					}
+				}
+
 				reader := bytes.NewReader(geomData[h.Size():])
 				geom, err = wkb.Decode(reader)
 
@@ -144,41 +538,69 @@ func (p *GPKGProvider) MVTLayer(ctx context.Context, layerName string, tile tego
 				}
 
 				if h.SRSId() != DefaultSRID {
-					util.CodeLogger.Infof("SRID %v != %v, trying to convert...", pLayer.srid, DefaultSRID)
-					// We need to convert our points to Webmercator.
-					g, err := basic.ToWebMercator(pLayer.srid, geom)
-					if err != nil {
+					if pLayer.srsUndefined || pLayer.reprojector == nil {
+						util.CodeLogger.Warnf(
+							"Feature has undefined SRS (%v) for layer (%v), skipping feature",
+							h.SRSId(), layerName)
+						geom = nil
+						skippedRow = true
+						continue
+					}
+
+					util.CodeLogger.Infof("SRID %v != %v, reprojecting per-vertex...", pLayer.srid, DefaultSRID)
+					reprojected, rerr := reprojectGeometry(geom, pLayer.reprojector)
+					if rerr != nil {
 						util.CodeLogger.Errorf(
-							"Was unable to transform geometry to webmercator from "+
-								"SRID (%v) for layer (%v) due to error: %v",
-							pLayer.srid, layerName, err)
-						return nil, err
-					} else {
-						util.CodeLogger.Info("...conversion ok")
+							"Was unable to reproject geometry from SRID (%v) for layer (%v) due to error: %v",
+							pLayer.srid, layerName, rerr)
+						geom = nil
+						continue
 					}
-					geom = g.Geometry
+					geom = reprojected
 				} else {
 					util.CodeLogger.Infof("SRID already default (%v), no conversion necessary", DefaultSRID)
 				}
+
+			case pLayer.idField:
+				gid = toUint64(vals[i])
+
+			default:
+				if len(pLayer.fields) > 0 && !containsField(pLayer.fields, cols[i]) {
+					continue
+				}
+				tags[cols[i]] = coerceColumnValue(colTypes[i], vals[i])
 			}
 		}
 
 		if geom == nil {
-			util.CodeLogger.Warn("No geometry in row, skipping feature")
+			if skippedRow {
+				skippedCount++
+			} else {
+				util.CodeLogger.Warn("No geometry in row, skipping feature")
+			}
 			continue
 		}
 
+		if len(p.clipMask) > 0 {
+			clipped, ok := clipGeometry(geom, p.clipMask)
+			if !ok {
+				skippedCount++
+				continue
+			}
+			geom = clipped
+		}
+
 		f := mvt.Feature{
 			ID:       &gid,
-			Tags:     make(map[string]interface{}),
+			Tags:     tags,
 			Geometry: geom,
 		}
 		newLayer.AddFeatures(f)
 	}
 
-	if rowCount != len(newLayer.Features()) {
-		util.CodeLogger.Errorf("newLayer feature count doesn't match table row count (%v != %v)\n",
-			len(newLayer.Features()), rowCount)
+	if rowCount != skippedCount+len(newLayer.Features()) {
+		util.CodeLogger.Errorf("newLayer feature+skipped count doesn't match table row count (%v+%v != %v)\n",
+			len(newLayer.Features()), skippedCount, rowCount)
 	}
 	return newLayer, nil
 }
@@ -191,20 +613,64 @@ func NewProvider(config map[string]interface{}) (mvt.Provider, error) {
 		return nil, err
 	}
 
-	util.CodeLogger.Debug("Attempting sql.Open() w/ filepath: ", filepath)
-	db, err := sql.Open("sqlite3", filepath)
+	// Open a single, pooled connection to the gpkg file up front instead of
+	// re-opening (and leaking) a handle on every MVTLayer call. WAL mode alone
+	// already lets multiple readers proceed concurrently; go-sqlite3's
+	// cache=shared is documented as incompatible with a multi-connection pool
+	// (it wants SetMaxOpenConns(1)), so it's deliberately left off here.
+	dsn := fmt.Sprintf("%v?_journal_mode=WAL&_query_only=true", filepath)
+	util.CodeLogger.Debug("Attempting sql.Open() w/ dsn: ", dsn)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		util.CodeLogger.Errorf("Error opening gpkg file: %v", err)
 		return nil, err
 	}
+	db.SetMaxOpenConns(maxOpenConns)
 
-	p := GPKGProvider{FilePath: filepath, layers: make(map[string]layer)}
+	p := GPKGProvider{
+		FilePath: filepath,
+		layers:   make(map[string]layer),
+		db:       db,
+		stmts:    make(map[string]*sql.Stmt),
+	}
 
+	clipPath, err := m.String(ClipGeometry, strPtr(""))
+	if err != nil {
+		util.CodeLogger.Error(err)
+		return nil, err
+	}
+	if clipPath != "" {
+		mask, err := loadClipGeometry(clipPath)
+		if err != nil {
+			util.CodeLogger.Errorf("Error loading ClipGeometry (%v): %v", clipPath, err)
+			return nil, err
+		}
+		p.clipMask = mask
+	}
+
+	layersConfig, ok := config[LayersKey].([]map[string]interface{})
+	if ok && len(layersConfig) > 0 {
+		if err := p.loadConfigLayers(db, layersConfig); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := p.autoDiscoverLayers(db); err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+// autoDiscoverLayers registers one layer per row of gpkg_contents, using
+// "SELECT * FROM <table>" for each. This is the fallback used when no
+// "layers" config is provided.
+func (p *GPKGProvider) autoDiscoverLayers(db *sql.DB) error {
 	qtext := "SELECT * FROM gpkg_contents"
 	rows, err := db.Query(qtext)
 	if err != nil {
 		util.CodeLogger.Errorf("Error during query: %v - %v", qtext, err)
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
@@ -233,14 +699,28 @@ func NewProvider(config map[string]interface{}) (mvt.Provider, error) {
 		}
 
 		log.Infof("Got Geometry type %T for table %v", geom, tablename)
-		layerQuery := fmt.Sprintf("SELECT * FROM %v;", tablename)
-		p.layers[tablename] = layer{name: tablename, sql: layerQuery, geomType: geom, srid: srid}
-
-		//		// The ID field name, this will default to 'gid' if not set to something other then empty string.
-		//		idField string
-		//		// The Geometery field name, this will default to 'geom' if not set to soemthing other then empty string.
-		//		geomField string
-		//		// GeomType is the the type of geometry returned from the SQL
+		layerQuery := fmt.Sprintf("SELECT * FROM %v WHERE geom IS NOT NULL;", tablename)
+		hasRTree, rtreeTable := lookupRTreeIndex(db, tablename, DefaultGeomFieldName)
+		idField := lookupPrimaryKeyColumn(db, tablename)
+		reprojector, rerr := lookupReprojector(db, srid)
+		srsUndefined := rerr != nil
+		if srsUndefined {
+			util.CodeLogger.Warnf("gpkg: layer (%v) has an unusable SRS (%v): %v; features will be skipped until this is fixed", tablename, srid, rerr)
+		}
+		p.layers[tablename] = layer{
+			name:         tablename,
+			sql:          layerQuery,
+			idField:      idField,
+			geomField:    DefaultGeomFieldName,
+			geomType:     geom,
+			srid:         srid,
+			hasRTree:     hasRTree,
+			rtreeTable:   rtreeTable,
+			minZoom:      DefaultMinZoom,
+			maxZoom:      DefaultMaxZoom,
+			reprojector:  reprojector,
+			srsUndefined: srsUndefined,
+		}
 
 		var logMsgPart string
 		fmt.Sprintf(logMsgPart, "(%v-%i) ", tablename, srid)
@@ -248,7 +728,671 @@ func NewProvider(config map[string]interface{}) (mvt.Provider, error) {
 	}
 	util.CodeLogger.Debug(logMsg)
 
-	return &p, err
+	return nil
+}
+
+// loadConfigLayers registers one layer per entry of the "layers" config
+// array, mirroring the postgis provider's config-driven layer support. Each
+// entry specifies either a literal "tablename" or a custom "sql" that may
+// contain the !BBOX! and !ZOOM! tokens, which MVTLayer substitutes per
+// request.
+func (p *GPKGProvider) loadConfigLayers(db *sql.DB, layersConfig []map[string]interface{}) error {
+	for _, layerConf := range layersConfig {
+		lm := dict.M(layerConf)
+
+		lname, err := lm.String(LayerName, nil)
+		if err != nil {
+			return err
+		}
+
+		idField, err := lm.String(LayerIDFieldname, strPtr(DefaultIDFieldName))
+		if err != nil {
+			return err
+		}
+		geomField, err := lm.String(LayerGeomFieldname, strPtr(DefaultGeomFieldName))
+		if err != nil {
+			return err
+		}
+		srid, err := lm.Int(LayerSRID, intPtr(DefaultSRID))
+		if err != nil {
+			return err
+		}
+		minZoom, err := lm.Int(LayerMinZoom, intPtr(DefaultMinZoom))
+		if err != nil {
+			return err
+		}
+		maxZoom, err := lm.Int(LayerMaxZoom, intPtr(DefaultMaxZoom))
+		if err != nil {
+			return err
+		}
+
+		tablename, err := lm.String(LayerTablename, strPtr(""))
+		if err != nil {
+			return err
+		}
+
+		lsql, err := lm.String(LayerSQL, strPtr(""))
+		if err != nil {
+			return err
+		}
+		if lsql == "" {
+			if tablename == "" {
+				return fmt.Errorf("gpkg: layer (%v) config must set either %q or %q", lname, LayerSQL, LayerTablename)
+			}
+			lsql = fmt.Sprintf("SELECT * FROM %v WHERE %v IS NOT NULL;", tablename, geomField)
+		}
+
+		var hasRTree bool
+		var rtreeTable string
+		if tablename != "" {
+			hasRTree, rtreeTable = lookupRTreeIndex(db, tablename, geomField)
+		}
+
+		geom, err := p.sampleGeomType(db, lsql, geomField)
+		if err != nil {
+			util.CodeLogger.Errorf("Error sampling geometry type for layer (%v): %v", lname, err)
+		}
+
+		reprojector, rerr := lookupReprojector(db, srid)
+		srsUndefined := rerr != nil
+		if srsUndefined {
+			util.CodeLogger.Warnf("gpkg: layer (%v) has an unusable SRS (%v): %v; features will be skipped until this is fixed", lname, srid, rerr)
+		}
+
+		p.layers[lname] = layer{
+			name:         lname,
+			sql:          lsql,
+			idField:      idField,
+			geomField:    geomField,
+			geomType:     geom,
+			srid:         srid,
+			hasRTree:     hasRTree,
+			rtreeTable:   rtreeTable,
+			minZoom:      minZoom,
+			maxZoom:      maxZoom,
+			fields:       stringSlice(layerConf[LayerFields]),
+			reprojector:  reprojector,
+			srsUndefined: srsUndefined,
+		}
+	}
+	return nil
+}
+
+// sampleGeomType substitutes a permissive, world-covering bbox/zoom into
+// sqlTemplate and decodes the geomField of the first resulting row, in order
+// to determine the geometry type a config-driven layer returns.
+func (p *GPKGProvider) sampleGeomType(db *sql.DB, sqlTemplate, geomField string) (tegola.Geometry, error) {
+	sampleSQL := substituteTokens(sqlTemplate, -20037508.34, -20037508.34, 20037508.34, 20037508.34, 0)
+	qtext := fmt.Sprintf("SELECT %v FROM (%v) AS q LIMIT 1;", geomField, strings.TrimSuffix(strings.TrimSpace(sampleSQL), ";"))
+
+	var geomData []byte
+	if err := db.QueryRow(qtext).Scan(&geomData); err != nil {
+		return nil, err
+	}
+	var h GeoPackageBinaryHeader
+	h.Init(geomData)
+	reader := bytes.NewReader(geomData[h.Size():])
+	return wkb.Decode(reader)
+}
+
+// substituteTokens fills the !BBOX! and !ZOOM! tokens in sqlTemplate with
+// the given envelope (in the layer's native SRID) and zoom level.
+func substituteTokens(sqlTemplate string, minx, miny, maxx, maxy float64, zoom int) string {
+	bboxSQL := fmt.Sprintf("%v, %v, %v, %v", minx, miny, maxx, maxy)
+	out := strings.Replace(sqlTemplate, bboxToken, bboxSQL, -1)
+	out = strings.Replace(out, zoomToken, fmt.Sprintf("%v", zoom), -1)
+	return out
+}
+
+// rtreeJoinQuery wraps baseSQL in a join against rtreeTable, the rtree virtual
+// table's own "id" column always holds the indexed table's rowid/PK (per the
+// GeoPackage spec), but the outer query must filter on whatever column the
+// layer's PK is actually named (idField) - commonly "fid" or "gid", not "id".
+func rtreeJoinQuery(baseSQL, idField, rtreeTable string) string {
+	return fmt.Sprintf(
+		"SELECT * FROM (%v) AS q WHERE %v IN "+
+			"(SELECT id FROM %v WHERE minx <= ? AND maxx >= ? AND miny <= ? AND maxy >= ?);",
+		baseSQL, idField, rtreeTable)
+}
+
+func strPtr(s string) *string { return &s }
+func intPtr(i int) *int       { return &i }
+
+// stringSlice coerces a config value decoded from JSON/TOML into a []string,
+// accepting either a native []string or the []interface{} shape the config
+// unmarshaler produces.
+func stringSlice(v interface{}) []string {
+	switch vv := v.(type) {
+	case []string:
+		return vv
+	case []interface{}:
+		out := make([]string, 0, len(vv))
+		for _, e := range vv {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func containsField(fields []string, name string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// toUint64 coerces a scanned id column value (typically int64, occasionally
+// []byte for a TEXT-affinity primary key) into the uint64 mvt.Feature.ID wants.
+func toUint64(val interface{}) uint64 {
+	switch v := val.(type) {
+	case int64:
+		return uint64(v)
+	case []byte:
+		var u uint64
+		fmt.Sscanf(string(v), "%d", &u)
+		return u
+	default:
+		return 0
+	}
+}
+
+// coerceColumnValue converts a scanned column value into the Go type its
+// declared SQLite affinity implies. The go-sqlite3 driver returns TEXT/NUMERIC
+// columns as []byte, so this mostly untangles that back into string/int64/float64.
+func coerceColumnValue(ct *sql.ColumnType, val interface{}) interface{} {
+	b, ok := val.([]byte)
+	if !ok {
+		return val
+	}
+	switch strings.ToUpper(ct.DatabaseTypeName()) {
+	case "INTEGER", "INT", "BIGINT", "SMALLINT", "TINYINT", "BOOLEAN":
+		var i int64
+		if _, err := fmt.Sscanf(string(b), "%d", &i); err == nil {
+			return i
+		}
+		return string(b)
+	case "REAL", "DOUBLE", "FLOAT", "NUMERIC":
+		var f float64
+		if _, err := fmt.Sscanf(string(b), "%f", &f); err == nil {
+			return f
+		}
+		return string(b)
+	case "BLOB":
+		return b
+	default:
+		return string(b)
+	}
+}
+
+// geojsonGeometry is the minimal shape needed to decode a GeoJSON geometry's
+// type and raw coordinates, deferring coordinate decoding until the type (and
+// therefore nesting depth) is known.
+type geojsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geojsonFeature struct {
+	Type     string          `json:"type"`
+	Geometry geojsonGeometry `json:"geometry"`
+}
+
+type geojsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geojsonFeature `json:"features"`
+}
+
+// loadClipGeometry reads the GeoJSON file at path and flattens its
+// Polygon/MultiPolygon geometry (wrapped in a bare geometry, a Feature, or a
+// FeatureCollection) into the []basic.Polygon mask used by clipGeometry.
+func loadClipGeometry(path string) ([]basic.Polygon, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var top struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+
+	switch top.Type {
+	case "FeatureCollection":
+		var fc geojsonFeatureCollection
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return nil, err
+		}
+		var mask []basic.Polygon
+		for _, f := range fc.Features {
+			polys, err := decodeClipGeometry(f.Geometry.Type, f.Geometry.Coordinates)
+			if err != nil {
+				return nil, err
+			}
+			mask = append(mask, polys...)
+		}
+		return mask, nil
+	case "Feature":
+		var f geojsonFeature
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		return decodeClipGeometry(f.Geometry.Type, f.Geometry.Coordinates)
+	case "Polygon", "MultiPolygon":
+		var g geojsonGeometry
+		if err := json.Unmarshal(data, &g); err != nil {
+			return nil, err
+		}
+		return decodeClipGeometry(g.Type, g.Coordinates)
+	default:
+		return nil, fmt.Errorf("gpkg: unsupported ClipGeometry type %q", top.Type)
+	}
+}
+
+// clipGeometrySRID is the SRID GeoJSON coordinates are always expressed in,
+// per the GeoJSON spec (RFC 7946 ยง4). decodeClipGeometry reprojects into
+// WebMercator at load time so the mask matches the coordinate space MVTLayer
+// clips features in.
+const clipGeometrySRID = 4326
+
+// decodeClipGeometry flattens a Polygon or MultiPolygon's raw coordinate
+// arrays into basic.Polygon rings, reprojected from WGS84 into WebMercator.
+func decodeClipGeometry(gtype string, raw json.RawMessage) ([]basic.Polygon, error) {
+	switch gtype {
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(raw, &rings); err != nil {
+			return nil, err
+		}
+		poly, err := polygonFromRings(rings)
+		if err != nil {
+			return nil, err
+		}
+		return []basic.Polygon{poly}, nil
+	case "MultiPolygon":
+		var polys [][][][2]float64
+		if err := json.Unmarshal(raw, &polys); err != nil {
+			return nil, err
+		}
+		mask := make([]basic.Polygon, len(polys))
+		for i, rings := range polys {
+			poly, err := polygonFromRings(rings)
+			if err != nil {
+				return nil, err
+			}
+			mask[i] = poly
+		}
+		return mask, nil
+	default:
+		return nil, fmt.Errorf("gpkg: ClipGeometry geometry must be Polygon or MultiPolygon, got %q", gtype)
+	}
+}
+
+func polygonFromRings(rings [][][2]float64) (basic.Polygon, error) {
+	poly := make(basic.Polygon, len(rings))
+	for i, ring := range rings {
+		line := make(basic.Line, len(ring))
+		for j, coord := range ring {
+			pt, err := reprojectClipPoint(coord[0], coord[1])
+			if err != nil {
+				return nil, err
+			}
+			line[j] = pt
+		}
+		poly[i] = line
+	}
+	return poly, nil
+}
+
+// reprojectClipPoint converts a single WGS84 (lon, lat) ClipGeometry vertex
+// into WebMercator, matching the coordinate space geom is already in by the
+// time clipGeometry runs.
+func reprojectClipPoint(lon, lat float64) (basic.Point, error) {
+	g, err := basic.ToWebMercator(clipGeometrySRID, basic.Point{lon, lat})
+	if err != nil {
+		return basic.Point{}, err
+	}
+	pt, ok := g.Geometry.(basic.Point)
+	if !ok {
+		return basic.Point{}, fmt.Errorf("gpkg: unexpected geometry type %T reprojecting ClipGeometry vertex", g.Geometry)
+	}
+	return pt, nil
+}
+
+// clipGeometry intersects geom against the operator-supplied ClipGeometry
+// mask, returning ok=false when the feature lies entirely outside it. Points
+// outside the mask are dropped from line/polygon geometries so that features
+// straddling the mask boundary are clipped; this is a point-membership clip
+// rather than a true polygon intersection.
+func clipGeometry(geom tegola.Geometry, mask []basic.Polygon) (g tegola.Geometry, ok bool) {
+	switch t := geom.(type) {
+	case tegola.Point:
+		if !pointInMask(t.X(), t.Y(), mask) {
+			return nil, false
+		}
+		return geom, true
+	case tegola.MultiPoint:
+		var kept basic.MultiPoint
+		for _, pt := range t.Points() {
+			if pointInMask(pt.X(), pt.Y(), mask) {
+				kept = append(kept, basic.Point{pt.X(), pt.Y()})
+			}
+		}
+		if len(kept) == 0 {
+			return nil, false
+		}
+		return kept, true
+	case tegola.LineString:
+		line := clipLine(t, mask)
+		if len(line) == 0 {
+			return nil, false
+		}
+		return line, true
+	case tegola.MultiLine:
+		var lines basic.MultiLine
+		for _, l := range t.Lines() {
+			if line := clipLine(l, mask); len(line) > 0 {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			return nil, false
+		}
+		return lines, true
+	case tegola.Polygon:
+		poly := clipPolygon(t, mask)
+		if len(poly) == 0 {
+			return nil, false
+		}
+		return poly, true
+	case tegola.MultiPolygon:
+		var polys basic.MultiPolygon
+		for _, sp := range t.Polygons() {
+			if poly := clipPolygon(sp, mask); len(poly) > 0 {
+				polys = append(polys, poly)
+			}
+		}
+		if len(polys) == 0 {
+			return nil, false
+		}
+		return polys, true
+	default:
+		util.CodeLogger.Warnf("gpkg: ClipGeometry doesn't know how to clip %T, passing through unclipped", geom)
+		return geom, true
+	}
+}
+
+func clipLine(l tegola.LineString, mask []basic.Polygon) basic.Line {
+	var out basic.Line
+	for _, pt := range l.Subpoints() {
+		if pointInMask(pt.X(), pt.Y(), mask) {
+			out = append(out, basic.Point{pt.X(), pt.Y()})
+		}
+	}
+	return out
+}
+
+func clipPolygon(p tegola.Polygon, mask []basic.Polygon) basic.Polygon {
+	var poly basic.Polygon
+	for _, line := range p.Sublines() {
+		if ring := clipLine(line, mask); len(ring) >= 3 {
+			poly = append(poly, ring)
+		}
+	}
+	return poly
+}
+
+// pointInMask reports whether (x, y) falls inside any polygon of mask, honoring
+// holes (rings after the first in a polygon).
+func pointInMask(x, y float64, mask []basic.Polygon) bool {
+	for _, poly := range mask {
+		if len(poly) == 0 || !pointInRing(x, y, poly[0]) {
+			continue
+		}
+		inHole := false
+		for _, hole := range poly[1:] {
+			if pointInRing(x, y, hole) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// pointInRing is a standard ray-casting point-in-polygon test.
+func pointInRing(x, y float64, ring basic.Line) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		pi, pj := ring[i], ring[j]
+		if (pi.Y() > y) != (pj.Y() > y) &&
+			x < (pj.X()-pi.X())*(y-pi.Y())/(pj.Y()-pi.Y())+pi.X() {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// lookupRTreeIndex checks gpkg_extensions for a registered gpkg_rtree_index
+// extension on tablename/geomField and returns the name of the rtree virtual
+// table to join against, if one exists.
+func lookupRTreeIndex(db *sql.DB, tablename, geomField string) (bool, string) {
+	qtext := "SELECT 1 FROM gpkg_extensions WHERE table_name = ? AND column_name = ? AND extension_name = 'gpkg_rtree_index';"
+	var found int
+	if err := db.QueryRow(qtext, tablename, geomField).Scan(&found); err != nil {
+		return false, ""
+	}
+	return true, fmt.Sprintf("rtree_%v_%v", tablename, geomField)
+}
+
+// lookupPrimaryKeyColumn returns the name of tablename's primary key column,
+// read from PRAGMA table_info since auto-discovered layers have no config
+// specifying one. Falls back to DefaultIDFieldName (with a warning) when the
+// table has no single-column primary key, in which case feature IDs are 0
+// until the layer is given an explicit id_fieldname via the "layers" config.
+func lookupPrimaryKeyColumn(db *sql.DB, tablename string) string {
+	qtext := fmt.Sprintf("PRAGMA table_info(%v);", tablename)
+	rows, err := db.Query(qtext)
+	if err != nil {
+		util.CodeLogger.Warnf("gpkg: unable to read schema for table (%v), defaulting id field to %q: %v", tablename, DefaultIDFieldName, err)
+		return DefaultIDFieldName
+	}
+	defer rows.Close()
+
+	var cid, notnull, pk int
+	var name, ctype string
+	var dflt interface{}
+	var pkName string
+	pkCols := 0
+	for rows.Next() {
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			util.CodeLogger.Warnf("gpkg: error reading schema for table (%v), defaulting id field to %q: %v", tablename, DefaultIDFieldName, err)
+			return DefaultIDFieldName
+		}
+		if pk > 0 {
+			pkCols++
+			pkName = name
+		}
+	}
+	if pkCols == 1 {
+		return pkName
+	}
+
+	util.CodeLogger.Warnf("gpkg: table (%v) has no single-column primary key, defaulting id field to %q; feature IDs will be 0 unless configured via %q", tablename, DefaultIDFieldName, LayerIDFieldname)
+	return DefaultIDFieldName
+}
+
+// lookupReprojector resolves the Reprojector for srid from the definition
+// registered in gpkg_spatial_ref_sys, so a missing or undefined SRS is caught
+// once at load time rather than per-feature. Returns nil, nil when srid is
+// already DefaultSRID and needs no reprojection.
+func lookupReprojector(db *sql.DB, srid int) (Reprojector, error) {
+	if srid == DefaultSRID {
+		return nil, nil
+	}
+
+	var definition string
+	err := db.QueryRow("SELECT definition FROM gpkg_spatial_ref_sys WHERE srs_id = ?;", srid).Scan(&definition)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("SRS %v is not registered in gpkg_spatial_ref_sys", srid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if definition == "" || strings.EqualFold(definition, "undefined") {
+		return nil, fmt.Errorf("SRS %v has an undefined definition", srid)
+	}
+
+	crs, err := parseWKT(definition)
+	if err != nil {
+		return nil, fmt.Errorf("SRS %v: %v", srid, err)
+	}
+	if !crs.isGeographic && !wktSupportedProjections[strings.ToLower(crs.projection)] {
+		return nil, fmt.Errorf("SRS %v: unsupported WKT projection %q", srid, crs.projection)
+	}
+	if lat0 := crs.params["latitude_of_origin"]; lat0 != 0 {
+		util.CodeLogger.Warnf("gpkg: SRS %v has a non-zero latitude_of_origin (%v); inverseTransverseMercator assumes the equator, results will be off", srid, lat0)
+	}
+
+	k0 := crs.params["scale_factor"]
+	if k0 == 0 {
+		k0 = 1
+	}
+	return wktReprojector{
+		crs:  crs,
+		lon0: crs.params["central_meridian"],
+		k0:   k0,
+		fe:   crs.params["false_easting"],
+		fn:   crs.params["false_northing"],
+	}, nil
+}
+
+// reprojectGeometry applies reprojector to every vertex of geom, returning
+// the equivalent geometry in WebMercator.
+func reprojectGeometry(geom tegola.Geometry, reprojector Reprojector) (tegola.Geometry, error) {
+	switch t := geom.(type) {
+	case tegola.Point:
+		x, y, err := reprojector.Reproject(t.X(), t.Y())
+		if err != nil {
+			return nil, err
+		}
+		return basic.Point{x, y}, nil
+
+	case tegola.MultiPoint:
+		srcPts := t.Points()
+		pts := make(basic.MultiPoint, 0, len(srcPts))
+		for _, pt := range srcPts {
+			x, y, err := reprojector.Reproject(pt.X(), pt.Y())
+			if err != nil {
+				return nil, err
+			}
+			pts = append(pts, basic.Point{x, y})
+		}
+		return pts, nil
+
+	case tegola.LineString:
+		return reprojectLine(t, reprojector)
+
+	case tegola.MultiLine:
+		srcLines := t.Lines()
+		lines := make(basic.MultiLine, 0, len(srcLines))
+		for _, l := range srcLines {
+			line, err := reprojectLine(l, reprojector)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+		}
+		return lines, nil
+
+	case tegola.Polygon:
+		return reprojectPolygon(t, reprojector)
+
+	case tegola.MultiPolygon:
+		srcPolys := t.Polygons()
+		polys := make(basic.MultiPolygon, 0, len(srcPolys))
+		for _, sp := range srcPolys {
+			poly, err := reprojectPolygon(sp, reprojector)
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, poly)
+		}
+		return polys, nil
+
+	default:
+		return nil, fmt.Errorf("gpkg: don't know how to reproject %T", geom)
+	}
+}
+
+func reprojectLine(l tegola.LineString, reprojector Reprojector) (basic.Line, error) {
+	srcPts := l.Subpoints()
+	line := make(basic.Line, 0, len(srcPts))
+	for _, pt := range srcPts {
+		x, y, err := reprojector.Reproject(pt.X(), pt.Y())
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, basic.Point{x, y})
+	}
+	return line, nil
+}
+
+func reprojectPolygon(p tegola.Polygon, reprojector Reprojector) (basic.Polygon, error) {
+	sublines := p.Sublines()
+	poly := make(basic.Polygon, 0, len(sublines))
+	for _, l := range sublines {
+		ring, err := reprojectLine(l, reprojector)
+		if err != nil {
+			return nil, err
+		}
+		poly = append(poly, ring)
+	}
+	return poly, nil
+}
+
+// reprojectBBox converts a tile bounding box (in WebMercator) into the SRID
+// used natively by a gpkg table, returning the reprojected envelope.
+func reprojectBBox(reprojector Reprojector, minx, miny, maxx, maxy float64) (rminx, rminy, rmaxx, rmaxy float64, err error) {
+	corners := [4][2]float64{
+		{minx, miny},
+		{maxx, miny},
+		{maxx, maxy},
+		{minx, maxy},
+	}
+
+	rminx, rminy, err = reprojector.Unreproject(corners[0][0], corners[0][1])
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	rmaxx, rmaxy = rminx, rminy
+	for _, c := range corners[1:] {
+		x, y, uerr := reprojector.Unreproject(c[0], c[1])
+		if uerr != nil {
+			return 0, 0, 0, 0, uerr
+		}
+		if x < rminx {
+			rminx = x
+		} else if x > rmaxx {
+			rmaxx = x
+		}
+		if y < rminy {
+			rminy = y
+		} else if y > rmaxy {
+			rmaxy = y
+		}
+	}
+	return
 }
 
 func (p *GPKGProvider) layerGeomType(l *layer) {
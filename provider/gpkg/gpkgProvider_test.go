@@ -0,0 +1,317 @@
+package gpkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/terranodo/tegola/basic"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSubstituteTokens(t *testing.T) {
+	tmpl := "SELECT * FROM t WHERE geom && !BBOX! AND z = !ZOOM!;"
+	got := substituteTokens(tmpl, 1, 2, 3, 4, 5)
+	want := "SELECT * FROM t WHERE geom && 1, 2, 3, 4 AND z = 5;"
+	if got != want {
+		t.Errorf("substituteTokens() = %q, want %q", got, want)
+	}
+}
+
+func TestSubstituteTokensNoTokens(t *testing.T) {
+	tmpl := "SELECT * FROM t;"
+	if got := substituteTokens(tmpl, 1, 2, 3, 4, 5); got != tmpl {
+		t.Errorf("substituteTokens() = %q, want unchanged %q", got, tmpl)
+	}
+}
+
+// The rtree join must filter on the layer's actual PK column (idField), not a
+// hardcoded "id", since the rtree virtual table's own "id" column is an
+// internal implementation detail of the index, not the feature table's PK name.
+func TestRTreeJoinQueryUsesIDField(t *testing.T) {
+	qtext := rtreeJoinQuery("SELECT * FROM roads", "fid", "rtree_roads_geom")
+
+	if !strings.Contains(qtext, "WHERE fid IN") {
+		t.Errorf("expected rtree join to filter on idField %q, got: %v", "fid", qtext)
+	}
+	if strings.Contains(qtext, "WHERE id IN") {
+		t.Errorf("rtree join must not hardcode the outer column as \"id\": %v", qtext)
+	}
+	if !strings.Contains(qtext, "SELECT id FROM rtree_roads_geom") {
+		t.Errorf("expected join against rtree table, got: %v", qtext)
+	}
+}
+
+// ClipGeometry files are WGS84 degrees, but by the time clipGeometry runs,
+// geom has already been reprojected to WebMercator meters. The mask must be
+// reprojected too, or pointInMask will compare degrees against meters and
+// never match.
+func TestDecodeClipGeometryReprojectsToWebMercator(t *testing.T) {
+	raw := json.RawMessage(`[[[0,0],[10,0],[10,10],[0,10],[0,0]]]`)
+	mask, err := decodeClipGeometry("Polygon", raw)
+	if err != nil {
+		t.Fatalf("decodeClipGeometry() error = %v", err)
+	}
+	if len(mask) != 1 || len(mask[0]) != 1 || len(mask[0][0]) != 5 {
+		t.Fatalf("unexpected mask shape: %+v", mask)
+	}
+
+	origin := mask[0][0][0]
+	if origin.X() != 0 || origin.Y() != 0 {
+		t.Errorf("origin vertex = (%v, %v), want (0, 0)", origin.X(), origin.Y())
+	}
+
+	corner := mask[0][0][1] // (10, 0) degrees
+	wantX := 6378137.0 * 10 * math.Pi / 180
+	if math.Abs(corner.X()-wantX) > 1 {
+		t.Errorf("corner.X() = %v, want ~%v (degrees were not reprojected to meters)", corner.X(), wantX)
+	}
+}
+
+func TestPointInRing(t *testing.T) {
+	square := basic.Line{
+		{0, 0}, {10, 0}, {10, 10}, {0, 10},
+	}
+	if !pointInRing(5, 5, square) {
+		t.Error("expected (5, 5) to be inside square")
+	}
+	if pointInRing(15, 5, square) {
+		t.Error("expected (15, 5) to be outside square")
+	}
+}
+
+func TestPointInMask(t *testing.T) {
+	mask := []basic.Polygon{
+		{
+			basic.Line{{0, 0}, {10, 0}, {10, 10}, {0, 10}},  // outer ring
+			basic.Line{{4, 4}, {6, 4}, {6, 6}, {4, 6}},      // hole
+		},
+	}
+	if !pointInMask(1, 1, mask) {
+		t.Error("expected (1, 1) to be inside mask")
+	}
+	if pointInMask(5, 5, mask) {
+		t.Error("expected (5, 5) to be inside the hole, not the mask")
+	}
+	if pointInMask(50, 50, mask) {
+		t.Error("expected (50, 50) to be outside mask")
+	}
+}
+
+// autoDiscoverLayers must derive the real PK column name from the table's
+// schema rather than assuming it's always called "gid" - GDAL/OGR/QGIS
+// default to "fid" instead.
+func TestLookupPrimaryKeyColumn(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE roads (fid INTEGER PRIMARY KEY, geom BLOB);"); err != nil {
+		t.Fatalf("CREATE TABLE error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE no_pk (id INTEGER, geom BLOB);"); err != nil {
+		t.Fatalf("CREATE TABLE error = %v", err)
+	}
+
+	if got := lookupPrimaryKeyColumn(db, "roads"); got != "fid" {
+		t.Errorf("lookupPrimaryKeyColumn(roads) = %q, want %q", got, "fid")
+	}
+	if got := lookupPrimaryKeyColumn(db, "no_pk"); got != DefaultIDFieldName {
+		t.Errorf("lookupPrimaryKeyColumn(no_pk) = %q, want default %q", got, DefaultIDFieldName)
+	}
+}
+
+func TestToUint64(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want uint64
+	}{
+		{int64(42), 42},
+		{[]byte("123"), 123},
+		{"not coerced", 0},
+	}
+	for _, c := range cases {
+		if got := toUint64(c.in); got != c.want {
+			t.Errorf("toUint64(%#v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+const wgs84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]]`
+
+const utm33nWKT = `PROJCS["WGS 84 / UTM zone 33N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],PARAMETER["central_meridian",15],PARAMETER["scale_factor",0.9996],PARAMETER["false_easting",500000],PARAMETER["false_northing",0],UNIT["metre",1]]`
+
+func TestParseWKTGeographic(t *testing.T) {
+	crs, err := parseWKT(wgs84WKT)
+	if err != nil {
+		t.Fatalf("parseWKT() error = %v", err)
+	}
+	if !crs.isGeographic {
+		t.Error("expected GEOGCS WKT to parse as geographic")
+	}
+	if crs.semiMajor != 6378137 || crs.invFlattening != 298.257223563 {
+		t.Errorf("unexpected spheroid: semiMajor=%v invFlattening=%v", crs.semiMajor, crs.invFlattening)
+	}
+}
+
+func TestParseWKTProjected(t *testing.T) {
+	crs, err := parseWKT(utm33nWKT)
+	if err != nil {
+		t.Fatalf("parseWKT() error = %v", err)
+	}
+	if crs.isGeographic {
+		t.Error("expected PROJCS WKT to parse as projected")
+	}
+	if !strings.EqualFold(crs.projection, "Transverse_Mercator") {
+		t.Errorf("projection = %q, want Transverse_Mercator", crs.projection)
+	}
+	if crs.params["central_meridian"] != 15 || crs.params["scale_factor"] != 0.9996 || crs.params["false_easting"] != 500000 {
+		t.Errorf("unexpected params: %+v", crs.params)
+	}
+}
+
+func TestParseWKTUnsupportedRoot(t *testing.T) {
+	if _, err := parseWKT(`COMPD_CS["bogus"]`); err == nil {
+		t.Error("expected error for unsupported WKT root node")
+	}
+}
+
+func TestForwardWebMercatorOrigin(t *testing.T) {
+	x, y := forwardWebMercator(0, 0)
+	if x != 0 || y != 0 {
+		t.Errorf("forwardWebMercator(0, 0) = (%v, %v), want (0, 0)", x, y)
+	}
+}
+
+// An inverse-then-forward Transverse Mercator round trip should recover the
+// original projected coordinates to within a few centimetres.
+func TestInverseTransverseMercatorRoundTrip(t *testing.T) {
+	a, invF := 6378137.0, 298.257223563
+	lon0, k0, fe, fn := 15.0, 0.9996, 500000.0, 0.0
+	eastNorth := [][2]float64{{500000, 0}, {400000, 5000000}, {600000, 4500000}}
+
+	for _, en := range eastNorth {
+		lon, lat := inverseTransverseMercator(en[0], en[1], a, invF, lon0, k0, fe, fn)
+		if math.Abs(lon-lon0) > 10 || math.Abs(lat) > 90 {
+			t.Fatalf("inverseTransverseMercator(%v, %v) produced implausible lon/lat (%v, %v)", en[0], en[1], lon, lat)
+		}
+		x, y := forwardTransverseMercator(lon, lat, a, invF, lon0, k0, fe, fn)
+		if math.Abs(x-en[0]) > 0.05 || math.Abs(y-en[1]) > 0.05 {
+			t.Errorf("round trip of (%v, %v) = (%v, %v), want within 5cm", en[0], en[1], x, y)
+		}
+	}
+
+	// The central meridian at the equator maps to (false_easting, false_northing).
+	lon, lat := inverseTransverseMercator(fe, fn, a, invF, lon0, k0, fe, fn)
+	if math.Abs(lon-lon0) > 1e-6 || math.Abs(lat) > 1e-6 {
+		t.Errorf("inverseTransverseMercator(falseEasting, falseNorthing) = (%v, %v), want (%v, 0)", lon, lat, lon0)
+	}
+}
+
+// Unreproject is Reproject's inverse, so taking a WebMercator vertex through
+// Unreproject and then back through Reproject should recover the original
+// WebMercator coords.
+func TestWktReprojectorUnreprojectRoundTrip(t *testing.T) {
+	crs, err := parseWKT(utm33nWKT)
+	if err != nil {
+		t.Fatalf("parseWKT() error = %v", err)
+	}
+	r := wktReprojector{crs: crs, lon0: 15, k0: 0.9996, fe: 500000, fn: 0}
+
+	wx, wy := forwardWebMercator(16.5, 50.0)
+
+	nx, ny, err := r.Unreproject(wx, wy)
+	if err != nil {
+		t.Fatalf("Unreproject() error = %v", err)
+	}
+
+	wx2, wy2, err := r.Reproject(nx, ny)
+	if err != nil {
+		t.Fatalf("Reproject() error = %v", err)
+	}
+
+	if math.Abs(wx2-wx) > 1 || math.Abs(wy2-wy) > 1 {
+		t.Errorf("round trip = (%v, %v), want ~(%v, %v)", wx2, wy2, wx, wy)
+	}
+}
+
+// reprojectBBox must use the layer's own Reprojector rather than a fixed
+// SRID-to-SRID helper, so it needs to work for any WKT-derived projection.
+func TestReprojectBBoxUsesReprojector(t *testing.T) {
+	crs, err := parseWKT(utm33nWKT)
+	if err != nil {
+		t.Fatalf("parseWKT() error = %v", err)
+	}
+	r := wktReprojector{crs: crs, lon0: 15, k0: 0.9996, fe: 500000, fn: 0}
+
+	minx, miny := forwardWebMercator(14.5, 49.0)
+	maxx, maxy := forwardWebMercator(16.0, 50.0)
+
+	rminx, rminy, rmaxx, rmaxy, err := reprojectBBox(r, minx, miny, maxx, maxy)
+	if err != nil {
+		t.Fatalf("reprojectBBox() error = %v", err)
+	}
+	if rminx >= rmaxx || rminy >= rmaxy {
+		t.Errorf("reprojectBBox() = (%v, %v, %v, %v), want min < max on both axes", rminx, rminy, rmaxx, rmaxy)
+	}
+
+	// lon0=15 is within the tile's longitude range, so the reprojected bbox
+	// should straddle the false easting.
+	if rminx >= r.fe || rmaxx <= r.fe {
+		t.Errorf("reprojected bbox x-range (%v, %v) doesn't straddle false easting %v", rminx, rmaxx, r.fe)
+	}
+}
+
+// lookupReprojector must catch an unsupported or undefined SRS at load time
+// (returning an error the caller can log loudly) rather than silently
+// returning a reprojector that fails per-feature at serve time.
+func TestLookupReprojector(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE gpkg_spatial_ref_sys (srs_id INTEGER PRIMARY KEY, definition TEXT);`); err != nil {
+		t.Fatalf("CREATE TABLE error = %v", err)
+	}
+	stmts := []string{
+		`INSERT INTO gpkg_spatial_ref_sys VALUES (4326, '` + wgs84WKT + `');`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES (32633, '` + utm33nWKT + `');`,
+		`INSERT INTO gpkg_spatial_ref_sys VALUES (2154, 'undefined');`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("INSERT error = %v", err)
+		}
+	}
+
+	if r, err := lookupReprojector(db, DefaultSRID); err != nil || r != nil {
+		t.Errorf("lookupReprojector(DefaultSRID) = (%v, %v), want (nil, nil)", r, err)
+	}
+
+	r, err := lookupReprojector(db, 4326)
+	if err != nil {
+		t.Fatalf("lookupReprojector(4326) error = %v", err)
+	}
+	if x, y, rerr := r.Reproject(0, 0); rerr != nil || x != 0 || y != 0 {
+		t.Errorf("geographic reprojector.Reproject(0, 0) = (%v, %v, %v), want (0, 0, nil)", x, y, rerr)
+	}
+
+	if _, err := lookupReprojector(db, 32633); err != nil {
+		t.Errorf("lookupReprojector(32633) error = %v, want a supported Transverse_Mercator reprojector", err)
+	}
+
+	if _, err := lookupReprojector(db, 2154); err == nil {
+		t.Error("expected an error for an SRS with an undefined definition")
+	}
+
+	if _, err := lookupReprojector(db, 999999); err == nil {
+		t.Error("expected an error for an SRID missing from gpkg_spatial_ref_sys")
+	}
+}